@@ -0,0 +1,96 @@
+// +build linux darwin
+
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package common
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// mmapSlabPool backs every slab with an anonymous mmap region instead of a normal Go allocation.
+// Because the pages aren't part of the Go heap, the OS can reclaim them under memory pressure
+// without the runtime's GC needing to be involved at all; ReturnSlab calls madvise(DONTNEED) so
+// pages we're not currently using are the first ones reclaimed. Selected by --memory-pool-use-mmap.
+type mmapSlabPool struct {
+	mu    sync.Mutex
+	slabs map[int64][][]byte // idle slabs, keyed by size
+
+	inUse         int64 // accessed via atomic
+	highWaterMark int64 // accessed via atomic
+}
+
+// NewMmapSlabPool constructs the mmap-backed BufferPool selected by --memory-pool-use-mmap.
+func NewMmapSlabPool() BufferPool {
+	return &mmapSlabPool{slabs: map[int64][][]byte{}}
+}
+
+func (p *mmapSlabPool) RentSlab(size int64) []byte {
+	p.mu.Lock()
+	idle := p.slabs[size]
+	var slab []byte
+	if n := len(idle); n > 0 {
+		slab = idle[n-1]
+		p.slabs[size] = idle[:n-1]
+	}
+	p.mu.Unlock()
+
+	if slab == nil {
+		mapped, err := unix.Mmap(-1, 0, int(size), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+		if err != nil {
+			// fall back to a normal heap allocation rather than failing the transfer outright
+			slab = make([]byte, size)
+		} else {
+			slab = mapped
+		}
+	}
+
+	inUse := atomic.AddInt64(&p.inUse, 1)
+	for {
+		hwm := atomic.LoadInt64(&p.highWaterMark)
+		if inUse <= hwm || atomic.CompareAndSwapInt64(&p.highWaterMark, hwm, inUse) {
+			break
+		}
+	}
+	return slab
+}
+
+func (p *mmapSlabPool) ReturnSlab(slab []byte) {
+	slab = slab[:cap(slab)] // so a later RentSlab of this size gets the full slab back
+	atomic.AddInt64(&p.inUse, -1)
+	_ = unix.Madvise(slab, unix.MADV_DONTNEED)
+
+	size := int64(cap(slab))
+	p.mu.Lock()
+	p.slabs[size] = append(p.slabs[size], slab)
+	p.mu.Unlock()
+}
+
+func (p *mmapSlabPool) CurrentBuffersInUse() int64 {
+	return atomic.LoadInt64(&p.inUse)
+}
+
+func (p *mmapSlabPool) CurrentHighWaterMark() int64 {
+	return atomic.LoadInt64(&p.highWaterMark)
+}
@@ -0,0 +1,105 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlabPoolRentReturnTracksInUseCount(t *testing.T) {
+	p := NewMultiSizeSlabPool(0)
+
+	a := p.RentSlab(64)
+	b := p.RentSlab(64)
+	if got := p.CurrentBuffersInUse(); got != 2 {
+		t.Fatalf("CurrentBuffersInUse() = %d, want 2", got)
+	}
+	if got := p.CurrentHighWaterMark(); got != 2 {
+		t.Fatalf("CurrentHighWaterMark() = %d, want 2", got)
+	}
+
+	p.ReturnSlab(a)
+	if got := p.CurrentBuffersInUse(); got != 1 {
+		t.Fatalf("CurrentBuffersInUse() after one return = %d, want 1", got)
+	}
+	// high water mark should not drop back down just because usage did
+	if got := p.CurrentHighWaterMark(); got != 2 {
+		t.Fatalf("CurrentHighWaterMark() after a return = %d, want unchanged 2", got)
+	}
+
+	p.ReturnSlab(b)
+	if got := p.CurrentBuffersInUse(); got != 0 {
+		t.Fatalf("CurrentBuffersInUse() after all returned = %d, want 0", got)
+	}
+}
+
+func TestSlabPoolRentReturnsRequestedSize(t *testing.T) {
+	p := NewMultiSizeSlabPool(0)
+
+	slab := p.RentSlab(128)
+	if len(slab) != 128 {
+		t.Fatalf("RentSlab(128) returned a slab of length %d", len(slab))
+	}
+	p.ReturnSlab(slab)
+
+	// renting the same size again after a return must still yield a slab of the requested size,
+	// whether or not the pool hands back the one just returned
+	reused := p.RentSlab(128)
+	if len(reused) != 128 {
+		t.Fatalf("RentSlab(128) after a return returned a slab of length %d", len(reused))
+	}
+}
+
+// TestSetGlobalBufferPoolSwapsTheProcessWidePool exercises the seam that a future cmd package would
+// use to honor --memory-pool-use-mmap: swapping GlobalBufferPool for a differently-configured pool
+// must actually redirect every subsequent Rent/ReturnSlab call.
+func TestSetGlobalBufferPoolSwapsTheProcessWidePool(t *testing.T) {
+	original := GlobalBufferPool
+	defer SetGlobalBufferPool(original)
+
+	replacement := NewMultiSizeSlabPool(0)
+	SetGlobalBufferPool(replacement)
+
+	if GlobalBufferPool != replacement {
+		t.Fatal("SetGlobalBufferPool did not replace GlobalBufferPool")
+	}
+
+	slab := GlobalBufferPool.RentSlab(16)
+	if replacement.CurrentBuffersInUse() != 1 {
+		t.Errorf("replacement pool CurrentBuffersInUse() = %d, want 1 after renting through GlobalBufferPool", replacement.CurrentBuffersInUse())
+	}
+	GlobalBufferPool.ReturnSlab(slab)
+}
+
+func TestSlabPoolFlushLoopDoesNotPanic(t *testing.T) {
+	p := NewMultiSizeSlabPool(5 * time.Millisecond)
+	slab := p.RentSlab(32)
+	p.ReturnSlab(slab)
+
+	time.Sleep(20 * time.Millisecond)
+
+	// the pool should still work perfectly well after a flush cycle
+	again := p.RentSlab(32)
+	if len(again) != 32 {
+		t.Fatalf("RentSlab(32) after a flush cycle returned a slab of length %d", len(again))
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// filesystemChunkWriter is the reference ChunkWriter implementation: it writes chunks straight to
+// a local file, at the offset implied by their index. It exists to prove out the ChunkWriter
+// abstraction for local-to-local jobs, and as the template for any future non-blob backend.
+type filesystemChunkWriter struct {
+	file      *os.File
+	chunkSize uint32
+	maxChunks uint32
+}
+
+// newFilesystemChunkWriter opens (creating if necessary) destination for writing, pre-allocating
+// its final size so that out-of-order WriteChunkAt calls don't need to grow the file themselves.
+func newFilesystemChunkWriter(destination string, fileSize int64, chunkSize uint32, maxChunks uint32) (*filesystemChunkWriter, error) {
+	f, err := os.OpenFile(destination, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(fileSize); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &filesystemChunkWriter{file: f, chunkSize: chunkSize, maxChunks: maxChunks}, nil
+}
+
+func (w *filesystemChunkWriter) ChunkSize() uint32 {
+	return w.chunkSize
+}
+
+func (w *filesystemChunkWriter) MaxChunks() uint32 {
+	return w.maxChunks
+}
+
+func (w *filesystemChunkWriter) WriteChunkAt(ctx context.Context, index int64, r io.ReadSeeker) error {
+	_, err := io.Copy(&offsetWriter{f: w.file, offset: index * int64(w.chunkSize)}, r)
+	return err
+}
+
+func (w *filesystemChunkWriter) Close(ctx context.Context) error {
+	return w.file.Close()
+}
+
+func (w *filesystemChunkWriter) Abort(ctx context.Context) error {
+	name := w.file.Name()
+	w.file.Close()
+	return os.Remove(name)
+}
+
+var _ ChunkWriter = (*filesystemChunkWriter)(nil)
+
+// offsetWriter writes sequentially to f starting at offset, advancing offset as it goes. It's a
+// stand-in for Go 1.20's io.OffsetWriter, which isn't available on the Go versions this repo
+// currently targets.
+type offsetWriter struct {
+	f      *os.File
+	offset int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
@@ -0,0 +1,118 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilesystemChunkWriterWritesChunksOutOfOrder(t *testing.T) {
+	const chunkSize = 4
+	dest := filepath.Join(t.TempDir(), "dest")
+
+	w, err := newFilesystemChunkWriter(dest, 2*chunkSize, chunkSize, 2)
+	if err != nil {
+		t.Fatalf("newFilesystemChunkWriter failed: %v", err)
+	}
+
+	// write index 1 before index 0, since WriteChunkAt must support out-of-order delivery
+	if err := w.WriteChunkAt(context.Background(), 1, bytes.NewReader([]byte("BBBB"))); err != nil {
+		t.Fatalf("WriteChunkAt(1) failed: %v", err)
+	}
+	if err := w.WriteChunkAt(context.Background(), 0, bytes.NewReader([]byte("AAAA"))); err != nil {
+		t.Fatalf("WriteChunkAt(0) failed: %v", err)
+	}
+
+	if err := w.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatalf("reading destination failed: %v", err)
+	}
+	if want := "AAAABBBB"; string(got) != want {
+		t.Errorf("destination contents = %q, want %q", got, want)
+	}
+}
+
+// writeAllChunks is a minimal stand-in for the scheduler chunkwriter.go describes: it splits source
+// into ChunkSize()-sized chunks and drives w.WriteChunkAt for each one before calling Close. Its
+// only parameter is the ChunkWriter interface, not *filesystemChunkWriter, to prove the interface
+// itself - not just this one implementation - is what a real scheduler would be written against.
+func writeAllChunks(ctx context.Context, w ChunkWriter, source []byte) error {
+	chunkSize := int64(w.ChunkSize())
+	for offset := int64(0); offset < int64(len(source)); offset += chunkSize {
+		end := offset + chunkSize
+		if end > int64(len(source)) {
+			end = int64(len(source))
+		}
+		index := offset / chunkSize
+		if err := w.WriteChunkAt(ctx, index, bytes.NewReader(source[offset:end])); err != nil {
+			return err
+		}
+	}
+	return w.Close(ctx)
+}
+
+func TestWriteAllChunksDrivesChunkWriterInterfaceGenerically(t *testing.T) {
+	const chunkSize = 3
+	source := []byte("abcdefghi")
+	dest := filepath.Join(t.TempDir(), "dest")
+
+	w, err := newFilesystemChunkWriter(dest, int64(len(source)), chunkSize, 3)
+	if err != nil {
+		t.Fatalf("newFilesystemChunkWriter failed: %v", err)
+	}
+
+	if err := writeAllChunks(context.Background(), w, source); err != nil {
+		t.Fatalf("writeAllChunks failed: %v", err)
+	}
+
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatalf("reading destination failed: %v", err)
+	}
+	if string(got) != string(source) {
+		t.Errorf("destination contents = %q, want %q", got, source)
+	}
+}
+
+func TestFilesystemChunkWriterAbortRemovesDestination(t *testing.T) {
+	const chunkSize = 4
+	dest := filepath.Join(t.TempDir(), "dest")
+
+	w, err := newFilesystemChunkWriter(dest, chunkSize, chunkSize, 1)
+	if err != nil {
+		t.Fatalf("newFilesystemChunkWriter failed: %v", err)
+	}
+
+	if err := w.Abort(context.Background()); err != nil {
+		t.Fatalf("Abort failed: %v", err)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("destination still exists after Abort: %v", err)
+	}
+}
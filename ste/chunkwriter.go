@@ -0,0 +1,58 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"context"
+	"io"
+)
+
+// ChunkWriter is the small, backend-agnostic interface a future chunked, paced, retry-aware
+// scheduler could drive in place of a specific uploader. blockBlobUploader implements it directly
+// (see WriteChunkAt/Close/Abort/MaxChunks in uploader-blockBlob.go) alongside its existing
+// Prologue/GenerateUploadFunc/Epilogue lifecycle, so the same transfer can eventually be scheduled
+// either way. A destination that isn't one of azcopy's usual blob types - local disk, or eventually
+// some other cloud's object store - only needs to implement this interface to be driven by that
+// scheduler; filesystemChunkWriter (chunkwriter-filesystem.go) is the reference example, written for
+// local-to-local jobs.
+//
+// NOTE: the scheduler itself isn't present in this tree - jobPartMgr/xferChannels (the pieces that
+// would decide when to call WriteChunkAt vs. the legacy GenerateUploadFunc path) aren't part of this
+// snapshot, and neither are pageBlobUploader/appendBlobUploader, so they can't be given ChunkWriter
+// adapters here either. Both blockBlobUploader and filesystemChunkWriter are fully usable
+// ChunkWriters today, and TestWriteAllChunksDrivesChunkWriterInterfaceGenerically (in
+// chunkwriter-filesystem_test.go) drives one of them through the interface alone, with no type
+// assertion back to the concrete implementation, to prove that much; what's missing is the
+// production caller that would pick between implementations for a real job.
+type ChunkWriter interface {
+	// ChunkSize returns the fixed size this writer wants each chunk delivered as.
+	ChunkSize() uint32
+	// MaxChunks returns the largest number of chunks this writer can accept for one destination.
+	MaxChunks() uint32
+	// WriteChunkAt writes the bytes read from r as the chunk at the given zero-based index.
+	WriteChunkAt(ctx context.Context, index int64, r io.ReadSeeker) error
+	// Close finalizes the destination once every chunk has been written successfully.
+	Close(ctx context.Context) error
+	// Abort discards whatever partial state Close would otherwise have finalized.
+	Abort(ctx context.Context) error
+}
+
+var _ ChunkWriter = (*blockBlobUploader)(nil)
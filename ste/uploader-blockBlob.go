@@ -29,7 +29,11 @@ import (
 	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-storage-azcopy/common"
 	"github.com/Azure/azure-storage-blob-go/azblob"
+	"io"
+	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -53,6 +57,13 @@ type blockBlobUploader struct {
 	putListIndicator int32       // accessed via sync.atomic
 	mu               *sync.Mutex // protects the fields below
 	blockIds         []string
+
+	// resumeUncommitted, when set, means uncommitted blocks left behind by a previous, interrupted
+	// attempt at this transfer should be reused rather than re-uploaded. alreadyStagedBlocks records
+	// which block indices were recovered this way; it's populated once in newBlockBlobUploader,
+	// before any chunk-func is scheduled, so it's safe to read without a lock.
+	resumeUncommitted   bool
+	alreadyStagedBlocks map[int32]bool
 }
 
 func newBlockBlobUploader(jptm IJobPartTransferMgr, destination string, p pipeline.Pipeline, pacer *pacer) (uploader, error) {
@@ -75,16 +86,66 @@ func newBlockBlobUploader(jptm IJobPartTransferMgr, destination string, p pipeli
 		return nil, err
 	}
 
-	return &blockBlobUploader{
-		jptm:         jptm,
-		blockBlobUrl: azblob.NewBlobURL(*destURL, p).ToBlockBlobURL(),
-		chunkSize:    chunkSize,
-		numChunks:    numChunks,
-		pipeline:     p,
-		pacer:        pacer,
-		mu:           &sync.Mutex{},
-		blockIds:     make([]string, numChunks),
-	}, nil
+	u := &blockBlobUploader{
+		jptm:              jptm,
+		blockBlobUrl:      azblob.NewBlobURL(*destURL, p).ToBlockBlobURL(),
+		chunkSize:         chunkSize,
+		numChunks:         numChunks,
+		pipeline:          p,
+		pacer:             pacer,
+		mu:                &sync.Mutex{},
+		blockIds:          make([]string, numChunks),
+		resumeUncommitted: info.ResumeUncommittedBlocks,
+	}
+
+	if u.resumeUncommitted {
+		// Reconcile against whatever this transfer already staged before it was interrupted, so we
+		// only re-stage the blocks that are actually missing. This relies on block IDs being
+		// deterministic (see newDeterministicBlockId) rather than random, so they can be recognized
+		// again on resume.
+		resp, err := u.blockBlobUrl.GetBlockList(jptm.Context(), azblob.BlockListUncommitted, azblob.LeaseAccessConditions{})
+		if err == nil {
+			u.alreadyStagedBlocks = make(map[int32]bool)
+			for _, block := range resp.UncommittedBlocks {
+				if blockIndex, ok := blockIndexFromId(info.JobID.String(), block.Name); ok && blockIndex < int32(numChunks) {
+					u.blockIds[blockIndex] = block.Name
+					u.alreadyStagedBlocks[blockIndex] = true
+				}
+			}
+		}
+		// if the blob doesn't exist yet (or has no uncommitted blocks), we just proceed as if this
+		// were a fresh upload; there's nothing to reconcile
+	}
+
+	return u, nil
+}
+
+// newDeterministicBlockId builds a block ID that encodes the transfer's job ID and the block's
+// index, instead of a random UUID, so that a resumed attempt can recognize its own uncommitted
+// blocks via GetBlockList(BlockListUncommitted) without needing a separate side-channel manifest.
+// It takes jobID as a plain string, rather than an IJobPartTransferMgr, so it can be unit tested
+// without a fake transfer manager.
+func newDeterministicBlockId(jobID string, blockIndex int32) string {
+	raw := fmt.Sprintf("%s-%08d", jobID, blockIndex)
+	return base64.StdEncoding.EncodeToString([]byte(raw))
+}
+
+// blockIndexFromId is the inverse of newDeterministicBlockId. It returns false if encodedBlockId
+// wasn't generated by this job (e.g. it's a stray block from some other, unrelated upload attempt).
+func blockIndexFromId(jobID string, encodedBlockId string) (int32, bool) {
+	raw, err := base64.StdEncoding.DecodeString(encodedBlockId)
+	if err != nil {
+		return 0, false
+	}
+	prefix := jobID + "-"
+	if !strings.HasPrefix(string(raw), prefix) {
+		return 0, false
+	}
+	blockIndex, err := strconv.Atoi(strings.TrimPrefix(string(raw), prefix))
+	if err != nil {
+		return 0, false
+	}
+	return int32(blockIndex), true
 }
 
 func (u *blockBlobUploader) ChunkSize() uint32 {
@@ -106,6 +167,42 @@ func (u *blockBlobUploader) RemoteFileExists() (bool, error) {
 	//      Can't just look at the response object, because its null if error is non null (where does that null come from?  Wouldn't a non-null value be reasonable in the 404 case?)
 }
 
+// RemoteFileTags returns the destination blob's current index tags, if any. It's used at Epilogue
+// time to decide what --overwrite-tags should merge (rather than blindly replace) on a resumed job.
+func (u *blockBlobUploader) RemoteFileTags() (azblob.BlobTagsMap, error) {
+	resp, err := u.blockBlobUrl.GetTags(u.jptm.Context(), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	tags := make(azblob.BlobTagsMap, len(resp.BlobTagSet))
+	for _, t := range resp.BlobTagSet {
+		tags[t.Key] = t.Value
+	}
+	return tags, nil
+}
+
+// mergeBlobTags returns the tag set that should actually be written to the destination: incoming
+// tags layered on top of existing ones (incoming wins on key conflicts) unless overwrite is set, in
+// which case incoming entirely replaces whatever tags are already there.
+//
+// SCOPE: this tree only contains blockBlobUploader - there's no pageBlobUploader, appendBlobUploader,
+// or blob-to-blob copier here for the same --blob-tags/--overwrite-tags merge policy to be applied
+// to, and no cmd package to parse those flags in the first place. mergeBlobTags/RemoteFileTags are
+// written so that whichever of those lands later only needs to call them, not reimplement the policy.
+func mergeBlobTags(existing, incoming azblob.BlobTagsMap, overwrite bool) azblob.BlobTagsMap {
+	if overwrite || len(existing) == 0 {
+		return incoming
+	}
+	merged := make(azblob.BlobTagsMap, len(existing)+len(incoming))
+	for k, v := range existing {
+		merged[k] = v
+	}
+	for k, v := range incoming {
+		merged[k] = v
+	}
+	return merged
+}
+
 func (u *blockBlobUploader) Prologue(leadingBytes []byte) {
 	// block blobs don't need any work done at this stage
 	// But we do need to remember the leading bytes because we'll need them later
@@ -121,10 +218,48 @@ func (u *blockBlobUploader) GenerateUploadFunc(id common.ChunkID, blockIndex int
 		}
 		u.setPutListNeed(plNotNeeded)
 		return u.generatePutWholeBlob(id, blockIndex, reader)
-	} else {
-		u.setPutListNeed(plNeeded)
-		return u.generatePutBlock(id, blockIndex, reader)
 	}
+
+	u.setPutListNeed(plNeeded)
+
+	// if the source is itself an HTTP(S) URL (e.g. an S3 presigned URL, or a SAS'd Azure URL, for
+	// an S3->Azure or Azure->Azure server-side copy), stage the block server-side instead of
+	// streaming the bytes through this process; generatePutBlockFromURL falls back to reader (the
+	// normal byte-streaming path) itself if the service rejects the server-side copy
+	info := u.jptm.Info()
+	if sourceURL, ok := parseS2SSourceURL(info.S2SSourceURL); ok {
+		offset, count := blockRangeForIndex(blockIndex, u.chunkSize, info.SourceSize)
+		return u.generatePutBlockFromURL(id, blockIndex, reader, sourceURL, offset, count)
+	}
+
+	return u.generatePutBlock(id, blockIndex, reader)
+}
+
+// parseS2SSourceURL reports whether raw names a server-side-copyable source (a non-empty, parsable
+// URL), returning the parsed form if so. A transfer whose source is a local file rather than a URL
+// has an empty S2SSourceURL, and an S2SSourceURL that fails to parse is treated the same as "not a
+// URL source" rather than failing the whole chunk - either way, GenerateUploadFunc just falls back
+// to the ordinary byte-streaming path.
+func parseS2SSourceURL(raw string) (url.URL, bool) {
+	if raw == "" {
+		return url.URL{}, false
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return url.URL{}, false
+	}
+	return *parsed, true
+}
+
+// blockRangeForIndex returns the byte offset and count of the block at blockIndex, for a source of
+// sourceSize bytes split into fixed chunkSize blocks (with the final block possibly shorter).
+func blockRangeForIndex(blockIndex int32, chunkSize uint32, sourceSize int64) (offset, count int64) {
+	offset = int64(blockIndex) * int64(chunkSize)
+	count = int64(chunkSize)
+	if remaining := sourceSize - offset; remaining < count {
+		count = remaining
+	}
+	return offset, count
 }
 
 // generatePutBlock generates a func to uploads the block of src data from given startIndex till the given chunkSize.
@@ -133,24 +268,138 @@ func (u *blockBlobUploader) generatePutBlock(id common.ChunkID, blockIndex int32
 	return createUploadChunkFunc(u.jptm, id, func() {
 		jptm := u.jptm
 
-		// step 1: generate block ID
-		blockId := common.NewUUID().String()
-		encodedBlockId := base64.StdEncoding.EncodeToString([]byte(blockId))
+		// step 1: this block may have survived from a previous, interrupted attempt at this
+		// transfer; if so, it was already recorded into blockIds by newBlockBlobUploader, it's
+		// already staged, and there's nothing left to do. This check must run before setBlockId,
+		// since setBlockId panics if that id is already populated with a different value.
+		if u.alreadyStaged(blockIndex) {
+			jptm.LogChunkStatus(id, common.EWaitReason.Body())
+			return
+		}
 
-		// step 2: save the block ID into the list of block IDs
+		// step 2: generate block ID and save it into the list of block IDs
+		encodedBlockId := u.newBlockId(blockIndex)
 		u.setBlockId(blockIndex, encodedBlockId)
 
 		// step 3: perform put block
-		u.jptm.LogChunkStatus(id, common.EWaitReason.Body())
-		body := newLiteRequestBodyPacer(reader, u.pacer)
-		_, err := u.blockBlobUrl.StageBlock(u.jptm.Context(), encodedBlockId, body, azblob.LeaseAccessConditions{}, nil)
+		jptm.LogChunkStatus(id, common.EWaitReason.Body())
+		u.stagePutBlockBytes(jptm, encodedBlockId, reader)
+	})
+}
+
+// alreadyStaged reports whether blockIndex was already staged by a previous, interrupted attempt at
+// this transfer and recovered into blockIds/alreadyStagedBlocks by newBlockBlobUploader's resume
+// reconciliation. Every code path that stages a block (generatePutBlock, generatePutBlockFromURL,
+// WriteChunkAt) must check this *before* calling setBlockId for that index, since setBlockId panics
+// on a second assignment of a different ID - checking here, once, means that ordering only has to be
+// gotten right in one place.
+func (u *blockBlobUploader) alreadyStaged(blockIndex int32) bool {
+	return u.resumeUncommitted && u.alreadyStagedBlocks[blockIndex]
+}
+
+// generatePutBlockFromURL generates a func that stages the block at blockIndex by having the
+// service read it directly from sourceURL, instead of streaming the bytes through this process.
+// It's used in place of generatePutBlock when the transfer source is itself an HTTP(S) URL carrying
+// its own SAS or presigned credentials (e.g. an S3->Azure or Azure->Azure server-side copy), which
+// eliminates local egress entirely. If the service returns the specific status that means "can't
+// verify/read this source for a server-side copy" (see isURLCopyUnsupported), this falls back to
+// reading the block through reader and staging it byte-by-byte, exactly as generatePutBlock would.
+//
+// This intentionally doesn't compare any client-side MD5 against what StageBlockFromURL staged: the
+// only MD5 available anywhere in a transfer's Info() is SourceContentMD5, which covers the *whole*
+// source, not this one block's byte range, so comparing it against resp.ContentMD5() (which the
+// service computes over just this block) can never succeed for a multi-block transfer and would fail
+// every such copy. Real per-block verification would need the expected MD5 of just this block's
+// source range, which nothing in this tree computes without reading the bytes locally - precisely
+// what a server-side copy exists to avoid.
+func (u *blockBlobUploader) generatePutBlockFromURL(id common.ChunkID, blockIndex int32, reader common.SingleChunkReader, sourceURL url.URL, offset, count int64) chunkFunc {
+
+	return createUploadChunkFunc(u.jptm, id, func() {
+		jptm := u.jptm
+
+		if u.alreadyStaged(blockIndex) {
+			jptm.LogChunkStatus(id, common.EWaitReason.Body())
+			return
+		}
+
+		encodedBlockId := u.newBlockId(blockIndex)
+		u.setBlockId(blockIndex, encodedBlockId)
+
+		jptm.LogChunkStatus(id, common.EWaitReason.Body())
+		_, err := u.blockBlobUrl.StageBlockFromURL(jptm.Context(), encodedBlockId, sourceURL, offset, count, azblob.LeaseAccessConditions{}, azblob.ModifiedAccessConditions{})
 		if err != nil {
-			jptm.FailActiveUpload("Staging block", err)
+			if isURLCopyUnsupported(err) {
+				// the service won't do a server-side copy from this particular source (e.g. it
+				// needs a same-account SAS the presigned URL doesn't carry); read the bytes through
+				// this process instead, exactly like a same-cloud upload would
+				jptm.Log(pipeline.LogWarning, fmt.Sprintf("StageBlockFromURL not supported for this source (%s); falling back to byte-streaming copy", err))
+				u.stagePutBlockBytes(jptm, encodedBlockId, reader)
+				return
+			}
+			jptm.FailActiveUpload("Staging block from URL", err)
 			return
 		}
 	})
 }
 
+// isURLCopyUnsupported reports whether err is specifically the service telling us it can't verify or
+// read the source for a server-side copy (HTTP 409 Conflict, ServiceCode CannotVerifyCopySource) -
+// not just any 4xx. 403 (auth), 404 (source gone), 412 (precondition failed) and 416 (bad range) are
+// genuine failures of this particular copy attempt, not "fall back to byte-streaming" signals; a
+// byte-streaming fallback after one of those would try to read through reader, which for a source
+// like an S3 presigned URL may have no local stream behind it at all, turning a clear error into a
+// confusing one.
+func isURLCopyUnsupported(err error) bool {
+	stgErr, ok := err.(azblob.StorageError)
+	if !ok || stgErr.Response() == nil {
+		return false
+	}
+	return stgErr.Response().StatusCode == http.StatusConflict && stgErr.ServiceCode() == "CannotVerifyCopySource"
+}
+
+// newBlockId returns the block ID to use for blockIndex: a deterministic one (recoverable across a
+// resumed attempt) if resumeUncommitted is set, or a fresh random one otherwise. It's shared by
+// every code path that stages a block, so the resume logic only needs to be gotten right once.
+func (u *blockBlobUploader) newBlockId(blockIndex int32) string {
+	if u.resumeUncommitted {
+		return newDeterministicBlockId(u.jptm.Info().JobID.String(), blockIndex)
+	}
+	return base64.StdEncoding.EncodeToString([]byte(common.NewUUID().String()))
+}
+
+// stagePutBlockBytes stages encodedBlockId by reading reader locally and streaming the bytes to the
+// service. It's the common tail of generatePutBlock and generatePutBlockFromURL's byte-streaming
+// fallback.
+func (u *blockBlobUploader) stagePutBlockBytes(jptm IJobPartTransferMgr, encodedBlockId string, reader common.SingleChunkReader) {
+	if err := u.stageBlockBytes(jptm.Context(), encodedBlockId, reader); err != nil {
+		jptm.FailActiveUpload("Staging block", err)
+	}
+}
+
+// stageBlockBytes reads r locally and stages it as encodedBlockId. It's the code actually shared by
+// stagePutBlockBytes (the chunk-func path) and WriteChunkAt (the ChunkWriter path); the two differ
+// only in how a staging failure gets reported back to their respective callers.
+//
+// Rather than letting r's own buffering allocate a fresh, chunk-sized slice for every block (which
+// was showing up as real GC churn when many large blobs upload concurrently), the read is taken into
+// a slab rented from common.GlobalBufferPool and returned as soon as StageBlock is done with it. The
+// pacer still governs the rate bytes are read from the source, since r is paced before being copied
+// into the slab.
+func (u *blockBlobUploader) stageBlockBytes(ctx context.Context, encodedBlockId string, r io.ReadSeeker) error {
+	pacedReader := newLiteRequestBodyPacer(r, u.pacer)
+
+	slab := common.GlobalBufferPool.RentSlab(int64(u.chunkSize))
+	defer common.GlobalBufferPool.ReturnSlab(slab)
+
+	n, err := io.ReadFull(pacedReader, slab)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	_, err = u.blockBlobUrl.StageBlock(ctx, encodedBlockId, bytes.NewReader(slab[:n]), azblob.LeaseAccessConditions{}, nil)
+	return err
+}
+
 // generates PUT Blob (for a blob that fits in a single put request)
 func (u *blockBlobUploader) generatePutWholeBlob(id common.ChunkID, blockIndex int32, reader common.SingleChunkReader) chunkFunc {
 
@@ -222,11 +471,40 @@ func (u *blockBlobUploader) Epilogue() {
 		}
 	}
 
+	// set blob index tags, if any were supplied (see mergeBlobTags for the merge-vs-replace policy
+	// and this method's scope)
+	if jptm.TransferStatus() > 0 {
+		blobTags := jptm.Info().BlobTags
+		if len(blobTags) > 0 {
+			overwriteTags := jptm.Info().OverwriteTags
+			// mergeBlobTags discards existingTags entirely when overwrite is true (see mergeBlobTags),
+			// so there's no point paying for the GetTags round-trip in that case - only fetch the
+			// existing tags when a merge might actually use them.
+			var existingTags azblob.BlobTagsMap
+			if !overwriteTags {
+				var err error
+				existingTags, err = u.RemoteFileTags()
+				if err != nil {
+					jptm.Log(pipeline.LogWarning, fmt.Sprintf("Checking existing tags failed: %s. New tags will be set without merging.", err))
+				}
+			}
+			tagsToSet := mergeBlobTags(existingTags, blobTags, overwriteTags)
+			_, err := u.blockBlobUrl.SetTags(jptm.Context(), nil, nil, tagsToSet)
+			if err != nil {
+				jptm.FailActiveUploadWithStatus("Setting BlobTags", err, common.ETransferStatus.BlobTagsFailure())
+				// don't return, because need cleanup below
+			}
+		}
+	}
+
 	// Cleanup
-	if jptm.TransferStatus() <= 0 { // TODO: <=0 or <0?
+	if jptm.TransferStatus() <= 0 && !u.resumeUncommitted { // TODO: <=0 or <0?
 		// If the transfer status value < 0, then transfer failed with some failure
 		// there is a possibility that some uncommitted blocks will be there
 		// Delete the uncommitted blobs
+		// (unless resumeUncommitted is set, in which case we deliberately leave the uncommitted
+		// blocks in place so that a subsequent attempt at this transfer can pick up where this one
+		// left off, instead of re-uploading everything)
 		// TODO: should we really do this deletion?  What if we are in an overwrite-existing-blob
 		//    situation. Deletion has very different semantics then, compared to not deleting.
 		deletionContext, _ := context.WithTimeout(context.Background(), 30*time.Second)
@@ -242,6 +520,46 @@ func (u *blockBlobUploader) Epilogue() {
 
 }
 
+// MaxChunks implements ChunkWriter, so that blockBlobUploader can be driven by the backend-agnostic
+// scheduler described in chunkwriter.go, in addition to the legacy chunk-func lifecycle above.
+func (u *blockBlobUploader) MaxChunks() uint32 {
+	return common.MaxNumberOfBlocksPerBlob
+}
+
+// WriteChunkAt implements ChunkWriter by staging r as the block at index, sharing both the resume
+// skip-check and the actual staging logic with the chunk-func path (see newBlockId/stageBlockBytes
+// and generatePutBlock) so the two entry points can't drift out of sync with each other.
+func (u *blockBlobUploader) WriteChunkAt(ctx context.Context, index int64, r io.ReadSeeker) error {
+	blockIndex := int32(index)
+
+	// set before the resume skip-check, as GenerateUploadFunc does, so Epilogue still commits the
+	// block list when every chunk was already staged by a previous attempt
+	u.setPutListNeed(plNeeded)
+
+	// this block may have survived from a previous, interrupted attempt; if so it's already
+	// staged, and this check must run before setBlockId (see generatePutBlock for why)
+	if u.alreadyStaged(blockIndex) {
+		return nil
+	}
+
+	encodedBlockId := u.newBlockId(blockIndex)
+	u.setBlockId(blockIndex, encodedBlockId)
+
+	return u.stageBlockBytes(ctx, encodedBlockId, r)
+}
+
+// Close implements ChunkWriter by running the same finalization logic as Epilogue.
+func (u *blockBlobUploader) Close(ctx context.Context) error {
+	u.Epilogue()
+	return nil
+}
+
+// Abort implements ChunkWriter by deleting whatever uncommitted blocks this attempt staged.
+func (u *blockBlobUploader) Abort(ctx context.Context) error {
+	_, err := u.blockBlobUrl.Delete(ctx, azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{})
+	return err
+}
+
 func (u *blockBlobUploader) setPutListNeed(value int32) {
 	// atomic because uploaders are used by multiple threads at the same time
 	previous := atomic.SwapInt32(&u.putListIndicator, value)
@@ -253,7 +571,7 @@ func (u *blockBlobUploader) setPutListNeed(value int32) {
 func (u *blockBlobUploader) setBlockId(index int32, value string) {
 	u.mu.Lock()
 	defer u.mu.Unlock()
-	if len(u.blockIds[index]) > 0 {
+	if existing := u.blockIds[index]; len(existing) > 0 && existing != value {
 		panic("block id set twice for one block")
 	}
 	u.blockIds[index] = value
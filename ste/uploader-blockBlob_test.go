@@ -0,0 +1,247 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+func TestDeterministicBlockIdRoundTrips(t *testing.T) {
+	jobID := "11111111-2222-3333-4444-555555555555"
+
+	for _, blockIndex := range []int32{0, 1, 41, 49999} {
+		encoded := newDeterministicBlockId(jobID, blockIndex)
+
+		gotIndex, ok := blockIndexFromId(jobID, encoded)
+		if !ok {
+			t.Fatalf("blockIndexFromId could not decode an ID this job just generated (index %d)", blockIndex)
+		}
+		if gotIndex != blockIndex {
+			t.Errorf("blockIndexFromId(%d) round-tripped to %d", blockIndex, gotIndex)
+		}
+	}
+}
+
+func TestBlockIndexFromIdRejectsOtherJobsAndGarbage(t *testing.T) {
+	encodedForOtherJob := newDeterministicBlockId("some-other-job-id", 3)
+
+	if _, ok := blockIndexFromId("this-job-id", encodedForOtherJob); ok {
+		t.Error("blockIndexFromId should reject a block ID belonging to a different job")
+	}
+	if _, ok := blockIndexFromId("this-job-id", "not valid base64!!"); ok {
+		t.Error("blockIndexFromId should reject a block ID that isn't valid base64")
+	}
+	if _, ok := blockIndexFromId("this-job-id", ""); ok {
+		t.Error("blockIndexFromId should reject an empty block ID")
+	}
+}
+
+// TestSetBlockIdResumeIsIdempotent guards against the bug where a recovered block, pre-populated
+// into blockIds by newBlockBlobUploader's resume reconciliation, caused setBlockId to panic the
+// first time the scheduled chunk-func ran for that same index with the same ID.
+func TestSetBlockIdResumeIsIdempotent(t *testing.T) {
+	u := &blockBlobUploader{
+		mu:       &sync.Mutex{},
+		blockIds: make([]string, 3),
+	}
+	u.blockIds[1] = "recovered-block-id"
+
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("setBlockId panicked on a resume re-assignment of the same value: %v", r)
+		}
+	}()
+	u.setBlockId(1, "recovered-block-id")
+
+	if u.blockIds[1] != "recovered-block-id" {
+		t.Errorf("blockIds[1] = %q, want unchanged", u.blockIds[1])
+	}
+}
+
+// TestAlreadyStagedGuardsResumedBlock directly reproduces the reported resume-path panic scenario:
+// newBlockBlobUploader's reconciliation pre-populates blockIds[blockIndex] with the recovered
+// block's own name for every block found via GetBlockList. alreadyStaged must report true for that
+// index so every staging path (generatePutBlock, generatePutBlockFromURL, WriteChunkAt) skips
+// straight past setBlockId instead of calling it with a now-already-populated index.
+func TestAlreadyStagedGuardsResumedBlock(t *testing.T) {
+	u := &blockBlobUploader{
+		mu:                  &sync.Mutex{},
+		blockIds:            make([]string, 3),
+		resumeUncommitted:   true,
+		alreadyStagedBlocks: map[int32]bool{1: true},
+	}
+	u.blockIds[1] = "recovered-block-name"
+
+	if !u.alreadyStaged(1) {
+		t.Fatal("alreadyStaged(1) = false, want true for a block recovered by resume reconciliation")
+	}
+	if u.alreadyStaged(0) {
+		t.Error("alreadyStaged(0) = true, want false for a block that was never recovered")
+	}
+
+	// the real bug: code that ignored alreadyStaged and called setBlockId unconditionally would
+	// panic here, since blockIds[1] is already populated with a different value than a freshly
+	// generated ID would be
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("a staging path that correctly checks alreadyStaged first should never reach setBlockId for this index, got panic: %v", r)
+		}
+	}()
+	if !u.alreadyStaged(1) {
+		u.setBlockId(1, "should-not-be-called")
+	}
+}
+
+// TestAlreadyStagedFalseWhenNotResuming ensures a non-resume transfer (resumeUncommitted == false)
+// never treats any index as already staged, even if alreadyStagedBlocks happens to be non-nil.
+func TestAlreadyStagedFalseWhenNotResuming(t *testing.T) {
+	u := &blockBlobUploader{
+		mu:                  &sync.Mutex{},
+		blockIds:            make([]string, 1),
+		resumeUncommitted:   false,
+		alreadyStagedBlocks: map[int32]bool{0: true},
+	}
+	if u.alreadyStaged(0) {
+		t.Error("alreadyStaged(0) = true, want false when resumeUncommitted is false")
+	}
+}
+
+func TestBlockRangeForIndex(t *testing.T) {
+	const chunkSize = 100
+	const sourceSize = 250
+
+	cases := []struct {
+		blockIndex int32
+		wantOffset int64
+		wantCount  int64
+	}{
+		{0, 0, 100},
+		{1, 100, 100},
+		{2, 200, 50}, // final, partial block
+	}
+
+	for _, c := range cases {
+		offset, count := blockRangeForIndex(c.blockIndex, chunkSize, sourceSize)
+		if offset != c.wantOffset || count != c.wantCount {
+			t.Errorf("blockRangeForIndex(%d, %d, %d) = (%d, %d), want (%d, %d)",
+				c.blockIndex, chunkSize, sourceSize, offset, count, c.wantOffset, c.wantCount)
+		}
+	}
+}
+
+func TestParseS2SSourceURLRejectsEmptyAndInvalid(t *testing.T) {
+	if _, ok := parseS2SSourceURL(""); ok {
+		t.Error("parseS2SSourceURL(\"\") = ok, want a local-source transfer to skip the URL-copy path")
+	}
+	if _, ok := parseS2SSourceURL("://not a url"); ok {
+		t.Error("parseS2SSourceURL of an unparsable string = ok, want it to fall back to byte-streaming instead")
+	}
+}
+
+func TestParseS2SSourceURLAcceptsValidURL(t *testing.T) {
+	got, ok := parseS2SSourceURL("https://example.blob.core.windows.net/container/blob?sig=abc")
+	if !ok {
+		t.Fatal("parseS2SSourceURL of a valid HTTPS URL = not ok, want ok")
+	}
+	if got.Host != "example.blob.core.windows.net" {
+		t.Errorf("parseS2SSourceURL host = %q, want %q", got.Host, "example.blob.core.windows.net")
+	}
+}
+
+func TestIsURLCopyUnsupportedIgnoresNonStorageErrors(t *testing.T) {
+	if isURLCopyUnsupported(errors.New("network blip")) {
+		t.Error("a plain error should never be treated as 'server-side copy unsupported'")
+	}
+}
+
+func TestMergeBlobTagsOverwriteReplacesExisting(t *testing.T) {
+	existing := azblob.BlobTagsMap{"old": "1"}
+	incoming := azblob.BlobTagsMap{"new": "2"}
+
+	got := mergeBlobTags(existing, incoming, true)
+	if len(got) != 1 || got["new"] != "2" {
+		t.Errorf("mergeBlobTags(overwrite=true) = %v, want only incoming tags", got)
+	}
+}
+
+// TestMergeBlobTagsOverwriteIgnoresExistingRegardlessOfValue documents the invariant that lets
+// Epilogue skip the RemoteFileTags GetTags round-trip entirely when OverwriteTags is set: the
+// result never depends on what existing actually is once overwrite is true, so there's nothing for
+// that extra request to usefully feed into.
+func TestMergeBlobTagsOverwriteIgnoresExistingRegardlessOfValue(t *testing.T) {
+	incoming := azblob.BlobTagsMap{"a": "1"}
+
+	withNilExisting := mergeBlobTags(nil, incoming, true)
+	withPopulatedExisting := mergeBlobTags(azblob.BlobTagsMap{"old": "stale"}, incoming, true)
+
+	if len(withNilExisting) != len(withPopulatedExisting) {
+		t.Fatalf("mergeBlobTags(overwrite=true) result depends on existing tags: %v vs %v", withNilExisting, withPopulatedExisting)
+	}
+	for k, v := range withNilExisting {
+		if withPopulatedExisting[k] != v {
+			t.Errorf("mergeBlobTags(overwrite=true)[%q] = %q with populated existing, want %q as with nil existing", k, withPopulatedExisting[k], v)
+		}
+	}
+}
+
+func TestMergeBlobTagsWithoutOverwriteUnionsAndIncomingWins(t *testing.T) {
+	existing := azblob.BlobTagsMap{"keep": "orig", "conflict": "orig"}
+	incoming := azblob.BlobTagsMap{"conflict": "new", "added": "new"}
+
+	got := mergeBlobTags(existing, incoming, false)
+	want := azblob.BlobTagsMap{"keep": "orig", "conflict": "new", "added": "new"}
+	if len(got) != len(want) {
+		t.Fatalf("mergeBlobTags() = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("mergeBlobTags()[%q] = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+func TestMergeBlobTagsNoExistingTagsReturnsIncomingUnchanged(t *testing.T) {
+	incoming := azblob.BlobTagsMap{"a": "1"}
+
+	got := mergeBlobTags(nil, incoming, false)
+	if len(got) != 1 || got["a"] != "1" {
+		t.Errorf("mergeBlobTags(no existing tags) = %v, want incoming unchanged", got)
+	}
+}
+
+func TestSetBlockIdStillPanicsOnGenuineConflict(t *testing.T) {
+	u := &blockBlobUploader{
+		mu:       &sync.Mutex{},
+		blockIds: make([]string, 3),
+	}
+	u.blockIds[1] = "first-id"
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("setBlockId should still panic when a block index is assigned two different IDs")
+		}
+	}()
+	u.setBlockId(1, "second-id")
+}
@@ -0,0 +1,239 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/Azure/azure-storage-azcopy/common"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+const (
+	// streamingInitialChunkSize is used for the first streamingGrowthInterval blocks of a streaming
+	// upload. It's deliberately modest, since we don't yet know whether the source is small or huge.
+	streamingInitialChunkSize = 8 * 1024 * 1024
+
+	// streamingMaxChunkSize caps the growth strategy below, so we never exceed the service's
+	// per-block size limit.
+	streamingMaxChunkSize = 100 * 1024 * 1024
+
+	// streamingGrowthInterval is how many blocks we stage at the current chunk size before doubling
+	// it, so that a multi-hundred-GB stream of unknown length doesn't run into the 50,000-block
+	// ceiling (common.MaxNumberOfBlocksPerBlob).
+	streamingGrowthInterval = 1000
+
+	// streamingMaxConcurrentBlocks bounds how many blocks may be buffered and in-flight to the
+	// service at once. Reading the next block blocks until a slot frees up, so a fast source behind
+	// a slow network can't grow an unbounded number of buffers or goroutines.
+	streamingMaxConcurrentBlocks = 16
+
+	// streamingIdleBufferCapacity bounds how many unused buffers streamingBufferPool keeps around
+	// for reuse; anything beyond that is left for the garbage collector instead of retained forever.
+	streamingIdleBufferCapacity = streamingMaxConcurrentBlocks
+)
+
+// StreamingUploader uploads a source of unknown length (e.g. stdin, or a pipe) to a block blob.
+// Unlike blockBlobUploader, it can't compute numChunks or pre-size blockIds up front, because
+// info.SourceSize isn't known. Instead it reads the source into pooled, chunkSize-sized buffers,
+// dispatches a StageBlock per buffer as it fills, and grows both blockIds and chunkSize as it goes.
+type StreamingUploader struct {
+	jptm         IJobPartTransferMgr
+	blockBlobUrl azblob.BlockBlobURL
+	pacer        *pacer
+	bufferPool   *streamingBufferPool
+
+	mu        sync.Mutex // protects the fields below
+	chunkSize uint32
+	blockIds  map[int32]string
+}
+
+// NewStreamingUploader creates an uploader for sources whose size isn't known ahead of time.
+//
+// TODO: nothing in cmd yet constructs a StreamingUploader - the "azcopy copy -" stdin source mode
+//  the request describes needs front-end wiring in the cmd package, which isn't present in this
+//  tree. This type is usable standalone (see Upload) once that wiring exists.
+func NewStreamingUploader(jptm IJobPartTransferMgr, blockBlobUrl azblob.BlockBlobURL, pacer *pacer) *StreamingUploader {
+	return &StreamingUploader{
+		jptm:         jptm,
+		blockBlobUrl: blockBlobUrl,
+		pacer:        pacer,
+		bufferPool:   newStreamingBufferPool(streamingIdleBufferCapacity),
+		chunkSize:    streamingInitialChunkSize,
+		blockIds:     make(map[int32]string),
+	}
+}
+
+// Upload reads source until EOF, staging one block per buffer-full read, then commits the
+// resulting block list. The blockIndex passed to StageBlock grows without bound, so sources far
+// larger than MaxNumberOfBlocksPerBlob * initial chunk size are handled by growChunkSize below.
+// Concurrency (and therefore how many buffers can be in flight at once) is capped at
+// streamingMaxConcurrentBlocks, so a fast source reading ahead of a slow network has bounded memory
+// use rather than growing a buffer and a goroutine per block.
+func (u *StreamingUploader) Upload(ctx context.Context, source io.Reader) error {
+	sem := make(chan struct{}, streamingMaxConcurrentBlocks)
+	var wg sync.WaitGroup
+	errs := make(chan error, 1)
+
+	for blockIndex := int32(0); ; blockIndex++ {
+		size := u.currentChunkSize()
+
+		sem <- struct{}{} // blocks until a slot is free, bounding buffers and goroutines in flight
+		buf := u.bufferPool.get(size)
+
+		n, readErr := io.ReadFull(source, buf)
+		if n > 0 {
+			wg.Add(1)
+			go func(blockIndex int32, buf []byte, n int) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				defer u.bufferPool.put(buf)
+				if err := u.stageBlock(ctx, blockIndex, buf[:n]); err != nil {
+					select {
+					case errs <- err:
+					default:
+					}
+				}
+			}(blockIndex, buf, n)
+		} else {
+			u.bufferPool.put(buf)
+			<-sem
+		}
+
+		if isStreamDone(readErr) {
+			break
+		}
+		if readErr != nil {
+			wg.Wait()
+			return readErr
+		}
+
+		if (blockIndex+1)%streamingGrowthInterval == 0 {
+			u.growChunkSize()
+		}
+	}
+
+	wg.Wait()
+	select {
+	case err := <-errs:
+		return err
+	default:
+	}
+
+	blobHttpHeader, metaData := u.jptm.BlobDstData(nil)
+	_, err := u.blockBlobUrl.CommitBlockList(ctx, u.orderedBlockIds(), blobHttpHeader, metaData, azblob.BlobAccessConditions{})
+	return err
+}
+
+func (u *StreamingUploader) stageBlock(ctx context.Context, blockIndex int32, body []byte) error {
+	blockId := base64.StdEncoding.EncodeToString([]byte(common.NewUUID().String()))
+
+	u.mu.Lock()
+	u.blockIds[blockIndex] = blockId
+	u.mu.Unlock()
+
+	pacedBody := newLiteRequestBodyPacer(bytes.NewReader(body), u.pacer)
+	_, err := u.blockBlobUrl.StageBlock(ctx, blockId, pacedBody, azblob.LeaseAccessConditions{}, nil)
+	return err
+}
+
+// isStreamDone reports whether err from io.ReadFull means the source is exhausted: either a clean
+// EOF with no partial block left over, or an EOF in the middle of filling a buffer (the last, short
+// block of the stream). Both are expected end-of-stream outcomes for Upload, not failures.
+func isStreamDone(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
+func (u *StreamingUploader) currentChunkSize() uint32 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.chunkSize
+}
+
+func (u *StreamingUploader) growChunkSize() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.chunkSize < streamingMaxChunkSize {
+		u.chunkSize *= 2
+		if u.chunkSize > streamingMaxChunkSize {
+			u.chunkSize = streamingMaxChunkSize
+		}
+	}
+}
+
+// streamingBufferPool is a bounded pool of reusable buffers shared by one streaming upload, so that
+// a fast source doesn't grow unbounded numbers of idle buffers (and so GC pressure stays flat
+// regardless of stream length). It's backed by a fixed-capacity channel rather than sync.Pool,
+// which has no capacity limit of its own and can retain an unbounded number of idle buffers under
+// bursty load; combined with streamingMaxConcurrentBlocks capping in-flight buffers, total memory
+// use is bounded. Buffers are sized to the current chunkSize when handed out; ones that no longer
+// match (because the growth strategy moved on) are simply dropped instead of reused.
+type streamingBufferPool struct {
+	idle chan []byte
+}
+
+func newStreamingBufferPool(capacity int) *streamingBufferPool {
+	return &streamingBufferPool{idle: make(chan []byte, capacity)}
+}
+
+func (p *streamingBufferPool) get(size uint32) []byte {
+	select {
+	case buf := <-p.idle:
+		if uint32(cap(buf)) >= size {
+			return buf[:size]
+		}
+	default:
+	}
+	return make([]byte, size)
+}
+
+func (p *streamingBufferPool) put(buf []byte) {
+	select {
+	case p.idle <- buf[:cap(buf)]:
+	default:
+		// the idle pool is already at capacity; let this buffer be garbage collected rather than
+		// retaining an unbounded number of them
+	}
+}
+
+// orderedBlockIds returns the staged block IDs sorted by block index, as CommitBlockList requires
+// them in the order they should be assembled.
+func (u *StreamingUploader) orderedBlockIds() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	indices := make([]int32, 0, len(u.blockIds))
+	for index := range u.blockIds {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+
+	ids := make([]string, len(indices))
+	for i, index := range indices {
+		ids[i] = u.blockIds[index]
+	}
+	return ids
+}
@@ -0,0 +1,147 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ste
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestIsStreamDoneRecognizesBothEOFVariants(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{io.EOF, true},
+		{io.ErrUnexpectedEOF, true},
+		{nil, false},
+		{errors.New("connection reset"), false},
+	}
+	for _, c := range cases {
+		if got := isStreamDone(c.err); got != c.want {
+			t.Errorf("isStreamDone(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestStreamingUploaderGrowChunkSizeCapsAtMax(t *testing.T) {
+	u := &StreamingUploader{chunkSize: streamingMaxChunkSize / 2}
+
+	u.growChunkSize()
+	if got := u.currentChunkSize(); got != streamingMaxChunkSize {
+		t.Fatalf("currentChunkSize() = %d, want %d", got, streamingMaxChunkSize)
+	}
+
+	// further growth shouldn't exceed the cap
+	u.growChunkSize()
+	if got := u.currentChunkSize(); got != streamingMaxChunkSize {
+		t.Fatalf("currentChunkSize() after growing past the cap = %d, want %d", got, streamingMaxChunkSize)
+	}
+}
+
+func TestStreamingUploaderOrderedBlockIdsSortsByIndex(t *testing.T) {
+	u := &StreamingUploader{
+		blockIds: map[int32]string{
+			2: "id-2",
+			0: "id-0",
+			1: "id-1",
+		},
+	}
+
+	got := u.orderedBlockIds()
+	want := []string{"id-0", "id-1", "id-2"}
+	if len(got) != len(want) {
+		t.Fatalf("orderedBlockIds() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("orderedBlockIds()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestStreamingBufferPoolIsBounded(t *testing.T) {
+	const capacity = 2
+	p := newStreamingBufferPool(capacity)
+
+	// returning more buffers than the pool's capacity shouldn't block or panic; the overflow is
+	// simply dropped
+	for i := 0; i < capacity+5; i++ {
+		p.put(make([]byte, 16))
+	}
+
+	seen := 0
+	for {
+		select {
+		case <-p.idle:
+			seen++
+			continue
+		default:
+		}
+		break
+	}
+	if seen > capacity {
+		t.Errorf("streamingBufferPool retained %d idle buffers, want at most %d", seen, capacity)
+	}
+}
+
+func TestStreamingBufferPoolReusesMatchingSize(t *testing.T) {
+	p := newStreamingBufferPool(4)
+	original := p.get(64)
+	p.put(original)
+
+	reused := p.get(64)
+	if len(reused) != 64 {
+		t.Fatalf("get(64) after a put returned length %d", len(reused))
+	}
+}
+
+// TestStreamingUploaderConcurrencyIsCapped exercises the semaphore pattern Upload uses, as a
+// regression test that acquiring streamingMaxConcurrentBlocks slots blocks a further acquire until
+// one is released - i.e. that the bound is real, not just a comment.
+func TestStreamingUploaderConcurrencyIsCapped(t *testing.T) {
+	sem := make(chan struct{}, streamingMaxConcurrentBlocks)
+	var wg sync.WaitGroup
+
+	for i := 0; i < streamingMaxConcurrentBlocks; i++ {
+		sem <- struct{}{}
+	}
+
+	acquired := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sem <- struct{}{}
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("acquiring one more slot than streamingMaxConcurrentBlocks should have blocked")
+	default:
+	}
+
+	<-sem // release one slot
+	<-acquired
+	wg.Wait()
+}